@@ -0,0 +1,198 @@
+// Copyright 2019 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/vicanso/pike/df"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	sinkTypeStdout = "stdout"
+	sinkTypeFile   = "file"
+	sinkTypeSyslog = "syslog"
+
+	// defaultSampleRate log every request when sampling isn't configured
+	defaultSampleRate = 1
+)
+
+type (
+	// SinkConfig a single access log output
+	SinkConfig struct {
+		// Type stdout, file or syslog, defaults to stdout
+		Type string
+		// Path log file path, only used when Type is file
+		Path string
+		// MaxSize max size in MB before the file sink rotates, only used when Type is file
+		MaxSize int `yaml:"maxSize"`
+		// MaxBackups max number of rotated files to retain, only used when Type is file
+		MaxBackups int `yaml:"maxBackups"`
+		// MaxAge max age in days to retain rotated files, only used when Type is file
+		MaxAge int `yaml:"maxAge"`
+		// Network syslog network (e.g. udp, tcp), only used when Type is syslog
+		Network string
+		// Address syslog address, only used when Type is syslog
+		Address string
+	}
+	// SamplingConfig controls how 2xx/3xx access logs are down-sampled;
+	// 4xx/5xx are always logged in full
+	SamplingConfig struct {
+		// Rate log 1 in Rate successful requests, defaults to 1 (log all)
+		Rate uint32
+	}
+	// AccessLoggerConfig configures NewAccessLogger
+	AccessLoggerConfig struct {
+		Sinks    []SinkConfig
+		Sampling SamplingConfig
+	}
+	// RequestInfo the fields recorded for a single proxied request
+	RequestInfo struct {
+		Method          string
+		Host            string
+		URI             string
+		Upstream        string
+		Backend         string
+		Status          int
+		Bytes           int
+		UpstreamLatency time.Duration
+		Latency         time.Duration
+		IP              string
+		RequestID       string
+		Cache           string
+	}
+	// AccessLogger a structured, sampled, multi-sink request logger
+	AccessLogger struct {
+		logger     *zap.Logger
+		sampleRate uint32
+		counter    uint32
+	}
+)
+
+var defaultAccessLogger *AccessLogger
+
+func init() {
+	cfg := &AccessLoggerConfig{}
+	for _, path := range df.ConfigPathList {
+		file := filepath.Join(path, "access_log.yml")
+		buf, _ := ioutil.ReadFile(file)
+		if len(buf) != 0 {
+			if err := yaml.Unmarshal(buf, cfg); err == nil {
+				break
+			}
+		}
+	}
+	l, err := NewAccessLogger(*cfg)
+	if err != nil {
+		l = &AccessLogger{
+			logger:     defaultLogger,
+			sampleRate: defaultSampleRate,
+		}
+	}
+	defaultAccessLogger = l
+}
+
+// NewAccessLogger builds an AccessLogger writing to every sink in cfg,
+// falling back to stdout JSON if no sink is configured
+func NewAccessLogger(cfg AccessLoggerConfig) (*AccessLogger, error) {
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	sinks := cfg.Sinks
+	if len(sinks) == 0 {
+		sinks = []SinkConfig{{Type: sinkTypeStdout}}
+	}
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for _, sink := range sinks {
+		ws, err := sinkWriter(sink)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, zapcore.NewCore(encoder, ws, zap.InfoLevel))
+	}
+	rate := cfg.Sampling.Rate
+	if rate == 0 {
+		rate = defaultSampleRate
+	}
+	return &AccessLogger{
+		logger:     zap.New(zapcore.NewTee(cores...)),
+		sampleRate: rate,
+	}, nil
+}
+
+// sinkWriter resolves a SinkConfig to a zapcore.WriteSyncer
+func sinkWriter(cfg SinkConfig) (zapcore.WriteSyncer, error) {
+	switch cfg.Type {
+	case "", sinkTypeStdout:
+		return zapcore.AddSync(os.Stdout), nil
+	case sinkTypeFile:
+		return zapcore.AddSync(&lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    cfg.MaxSize,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAge,
+		}), nil
+	case sinkTypeSyslog:
+		w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO, "pike")
+		if err != nil {
+			return nil, err
+		}
+		return zapcore.AddSync(w), nil
+	default:
+		return zapcore.Lock(zapcore.AddSync(ioutil.Discard)), nil
+	}
+}
+
+// Access returns the default access logger
+func Access() *AccessLogger {
+	return defaultAccessLogger
+}
+
+// shouldSample reports whether this request should be logged: all 4xx/5xx
+// are always logged, 2xx/3xx are logged 1 in sampleRate
+func (l *AccessLogger) shouldSample(status int) bool {
+	if status >= 400 || l.sampleRate <= 1 {
+		return true
+	}
+	return atomic.AddUint32(&l.counter, 1)%l.sampleRate == 0
+}
+
+// Log records a single proxied request
+func (l *AccessLogger) Log(info RequestInfo) {
+	if !l.shouldSample(info.Status) {
+		return
+	}
+	l.logger.Info("access",
+		zap.String("method", info.Method),
+		zap.String("host", info.Host),
+		zap.String("uri", info.URI),
+		zap.String("upstream", info.Upstream),
+		zap.String("backend", info.Backend),
+		zap.Int("status", info.Status),
+		zap.Int("bytes", info.Bytes),
+		zap.Duration("upstreamLatency", info.UpstreamLatency),
+		zap.Duration("latency", info.Latency),
+		zap.String("ip", info.IP),
+		zap.String("requestId", info.RequestID),
+		zap.String("cache", info.Cache),
+	)
+}
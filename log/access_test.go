@@ -0,0 +1,58 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestAccessLoggerShouldSample(t *testing.T) {
+	l := &AccessLogger{sampleRate: 5}
+
+	if !l.shouldSample(500) {
+		t.Fatalf("expect 5xx responses to always be sampled")
+	}
+	if !l.shouldSample(404) {
+		t.Fatalf("expect 4xx responses to always be sampled")
+	}
+
+	sampled := 0
+	for i := 0; i < 10; i++ {
+		if l.shouldSample(200) {
+			sampled++
+		}
+	}
+	if sampled != 2 {
+		t.Fatalf("expect 1 in 5 successful requests to be sampled over 10 tries, got %d", sampled)
+	}
+}
+
+func TestAccessLoggerShouldSampleAllWhenRateIsOne(t *testing.T) {
+	l := &AccessLogger{sampleRate: 1}
+	for i := 0; i < 5; i++ {
+		if !l.shouldSample(200) {
+			t.Fatalf("expect every request to be sampled when rate is 1")
+		}
+	}
+}
+
+func TestSinkWriterDefaultsToStdout(t *testing.T) {
+	ws, err := sinkWriter(SinkConfig{})
+	if err != nil {
+		t.Fatalf("sinkWriter fail, %v", err)
+	}
+	if ws != zapcore.AddSync(os.Stdout) {
+		t.Fatalf("expect an unconfigured sink type to write to stdout, not be silently discarded")
+	}
+}
+
+func TestSinkWriterStdoutType(t *testing.T) {
+	ws, err := sinkWriter(SinkConfig{Type: sinkTypeStdout})
+	if err != nil {
+		t.Fatalf("sinkWriter fail, %v", err)
+	}
+	if ws != zapcore.AddSync(os.Stdout) {
+		t.Fatalf("expect sinkTypeStdout to write to stdout")
+	}
+}
@@ -0,0 +1,47 @@
+package upstream
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vicanso/cod"
+	"github.com/vicanso/pike/log"
+)
+
+// cacheStatusKey context key a cache middleware, if installed, is expected
+// to set via c.Set so access logs can report whether the response was
+// served from cache; left as "-" when nothing sets it
+const cacheStatusKey = "pikeCacheStatus"
+
+// logAccess records one structured access log line per request proxied by
+// Proxy, using the backend address the target picker stashed via
+// backendAddrKey
+func logAccess(c *cod.Context, us *Upstream, start time.Time, err error) {
+	status := c.StatusCode
+	if err != nil && status == 0 {
+		status = http.StatusInternalServerError
+	}
+	bytesSent := 0
+	if c.BodyBuffer != nil {
+		bytesSent = c.BodyBuffer.Len()
+	}
+	cache, _ := c.Get(cacheStatusKey).(string)
+	if cache == "" {
+		cache = "-"
+	}
+	latency := time.Since(start)
+	log.Access().Log(log.RequestInfo{
+		Method:          c.Request.Method,
+		Host:            c.Request.Host,
+		URI:             c.Request.RequestURI,
+		Upstream:        us.Name,
+		Backend:         backendAddr(c),
+		Status:          status,
+		Bytes:           bytesSent,
+		UpstreamLatency: latency,
+		Latency:         latency,
+		IP:              c.RealIP(),
+		RequestID:       c.GetRequestHeader(headerXRequestID),
+		Cache:           cache,
+	})
+}
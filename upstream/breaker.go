@@ -0,0 +1,163 @@
+package upstream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vicanso/pike/log"
+)
+
+type breakerState int32
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+const (
+	defaultBreakerBuckets          = 10
+	defaultBreakerMinRequestVolume = 20
+	defaultBreakerFailureRatio     = 0.5
+	defaultBreakerCooldown         = 10 * time.Second
+	breakerBucketInterval          = time.Second
+)
+
+type bucket struct {
+	successes uint32
+	failures  uint32
+}
+
+// breaker a per-backend hystrix-style circuit breaker with three states
+// (closed/open/half-open). It counts rolling-window failures in fixed 1s
+// buckets, trips to open once the failure ratio exceeds a threshold (with
+// a minimum request volume), refuses selection during a cooldown window,
+// then allows a single probe request in half-open before closing again.
+type breaker struct {
+	url string
+
+	mu         sync.Mutex
+	buckets    []bucket
+	lastBucket int64
+
+	minRequestVolume uint32
+	failureRatio     float64
+	cooldown         time.Duration
+
+	state            int32
+	openedAt         int64
+	halfOpenInFlight int32
+}
+
+func newBreaker(url string, cfg BreakerConfig) *breaker {
+	buckets := cfg.Buckets
+	if buckets <= 0 {
+		buckets = defaultBreakerBuckets
+	}
+	minVolume := cfg.MinRequestVolume
+	if minVolume == 0 {
+		minVolume = defaultBreakerMinRequestVolume
+	}
+	ratio := cfg.FailureRatio
+	if ratio <= 0 {
+		ratio = defaultBreakerFailureRatio
+	}
+	cooldown := defaultBreakerCooldown
+	if cfg.CooldownSeconds > 0 {
+		cooldown = time.Duration(cfg.CooldownSeconds) * time.Second
+	}
+	return &breaker{
+		url:              url,
+		buckets:          make([]bucket, buckets),
+		minRequestVolume: minVolume,
+		failureRatio:     ratio,
+		cooldown:         cooldown,
+	}
+}
+
+// advanceLocked returns the bucket for now, clearing any buckets that the
+// rolling window has advanced past since the last call. b.mu must be held.
+func (b *breaker) advanceLocked() *bucket {
+	now := time.Now().UnixNano() / int64(breakerBucketInterval)
+	size := int64(len(b.buckets))
+	steps := now - b.lastBucket
+	if steps > size {
+		steps = size
+	}
+	for i := int64(0); i < steps; i++ {
+		idx := int((b.lastBucket + i + 1) % size)
+		b.buckets[idx] = bucket{}
+	}
+	b.lastBucket = now
+	return &b.buckets[now%size]
+}
+
+// Allow reports whether a request may be sent to this backend
+func (b *breaker) Allow() bool {
+	switch breakerState(atomic.LoadInt32(&b.state)) {
+	case breakerOpen:
+		openedAt := atomic.LoadInt64(&b.openedAt)
+		if time.Since(time.Unix(0, openedAt)) < b.cooldown {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&b.state, int32(breakerOpen), int32(breakerHalfOpen)) {
+			atomic.StoreInt32(&b.halfOpenInFlight, 1)
+			log.Default().Info("circuit breaker half-open, probing " + b.url)
+			return true
+		}
+		return false
+	case breakerHalfOpen:
+		return atomic.CompareAndSwapInt32(&b.halfOpenInFlight, 0, 1)
+	default:
+		return true
+	}
+}
+
+// RecordSuccess records a successful request against this backend
+func (b *breaker) RecordSuccess() {
+	b.mu.Lock()
+	b.advanceLocked().successes++
+	b.mu.Unlock()
+	if breakerState(atomic.LoadInt32(&b.state)) == breakerHalfOpen {
+		atomic.StoreInt32(&b.halfOpenInFlight, 0)
+		atomic.StoreInt32(&b.state, int32(breakerClosed))
+		log.Default().Info("circuit breaker closed " + b.url)
+	}
+}
+
+// RecordFailure records a failed request (5xx, timeout, dial error)
+func (b *breaker) RecordFailure() {
+	b.mu.Lock()
+	b.advanceLocked().failures++
+	b.mu.Unlock()
+	if breakerState(atomic.LoadInt32(&b.state)) == breakerHalfOpen {
+		atomic.StoreInt32(&b.halfOpenInFlight, 0)
+		atomic.StoreInt32(&b.state, int32(breakerOpen))
+		atomic.StoreInt64(&b.openedAt, time.Now().UnixNano())
+		log.Default().Warn("circuit breaker re-opened " + b.url)
+		return
+	}
+	if b.shouldTrip() && atomic.CompareAndSwapInt32(&b.state, int32(breakerClosed), int32(breakerOpen)) {
+		atomic.StoreInt64(&b.openedAt, time.Now().UnixNano())
+		log.Default().Warn("circuit breaker opened " + b.url)
+	}
+}
+
+// shouldTrip reports whether the rolling-window failure ratio has crossed
+// the configured threshold with enough volume to be meaningful
+func (b *breaker) shouldTrip() bool {
+	var successes, failures uint32
+	b.mu.Lock()
+	b.advanceLocked()
+	for _, bucket := range b.buckets {
+		successes += bucket.successes
+		failures += bucket.failures
+	}
+	b.mu.Unlock()
+	total := successes + failures
+	if total < b.minRequestVolume {
+		return false
+	}
+	return float64(failures)/float64(total) >= b.failureRatio
+}
@@ -0,0 +1,64 @@
+package upstream
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerTripsAndRecovers(t *testing.T) {
+	b := newBreaker("http://127.0.0.1:5000", BreakerConfig{
+		MinRequestVolume: 2,
+		FailureRatio:     0.5,
+	})
+	if !b.Allow() {
+		t.Fatalf("expect closed breaker to allow requests")
+	}
+	b.RecordFailure()
+	b.RecordFailure()
+	if b.Allow() {
+		t.Fatalf("expect breaker to be open after crossing failure ratio")
+	}
+
+	// simulate the cooldown window having elapsed
+	atomic.StoreInt64(&b.openedAt, time.Now().Add(-2*b.cooldown).UnixNano())
+
+	if !b.Allow() {
+		t.Fatalf("expect half-open probe to be allowed once cooldown elapses")
+	}
+	if b.Allow() {
+		t.Fatalf("expect only a single in-flight half-open probe")
+	}
+	b.RecordSuccess()
+	if !b.Allow() {
+		t.Fatalf("expect breaker to be closed again after a successful probe")
+	}
+}
+
+func TestBreakerStaysClosedBelowMinVolume(t *testing.T) {
+	b := newBreaker("http://127.0.0.1:5000", BreakerConfig{
+		MinRequestVolume: 10,
+		FailureRatio:     0.1,
+	})
+	b.RecordFailure()
+	b.RecordFailure()
+	if !b.Allow() {
+		t.Fatalf("expect breaker to stay closed below the minimum request volume")
+	}
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	b := newBreaker("http://127.0.0.1:5000", BreakerConfig{
+		MinRequestVolume: 1,
+		FailureRatio:     0.5,
+	})
+	b.RecordFailure()
+	atomic.StoreInt64(&b.openedAt, time.Now().Add(-2*b.cooldown).UnixNano())
+	if !b.Allow() {
+		t.Fatalf("expect half-open probe to be allowed")
+	}
+	b.RecordFailure()
+	if breakerState(atomic.LoadInt32(&b.state)) != breakerOpen {
+		t.Fatalf("expect a failed probe to re-open the breaker")
+	}
+}
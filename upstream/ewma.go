@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ewmaDecayWindow controls how quickly the EWMA forgets old samples: the
+// decay applied to a sample scales with how long it has been since the
+// previous one, so a backend that has gone idle decays back toward zero
+// instead of keeping a stale high latency forever.
+const ewmaDecayWindow = 10 * time.Second
+
+// ewmaStat tracks the exponentially-weighted moving average latency and
+// the number of in-flight requests for a single backend, used by the
+// p2cEWMA policy to rank two randomly chosen candidates.
+type ewmaStat struct {
+	mu         sync.Mutex
+	value      float64
+	lastSample int64
+
+	inflight int32
+}
+
+// Inc increments the in-flight request count, mirroring HTTPUpstream.Inc
+// used by policyLeastconn
+func (e *ewmaStat) Inc() {
+	atomic.AddInt32(&e.inflight, 1)
+}
+
+// Dec decrements the in-flight request count
+func (e *ewmaStat) Dec() {
+	atomic.AddInt32(&e.inflight, -1)
+}
+
+// Update folds a new round-trip-time sample into the EWMA, decaying faster
+// the longer it has been since the previous sample
+func (e *ewmaStat) Update(rtt time.Duration) {
+	now := time.Now().UnixNano()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	last := e.lastSample
+	e.lastSample = now
+	if last == 0 {
+		e.value = float64(rtt)
+		return
+	}
+	elapsed := time.Duration(now - last)
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(ewmaDecayWindow))
+	e.value = e.value*(1-alpha) + float64(rtt)*alpha
+}
+
+// cost returns ewma_latency * (inflight+1), the ranking score used to pick
+// between two candidates: a backend that is both fast and lightly loaded
+// wins. The latency term is decayed lazily here based on time elapsed
+// since the last sample, so a backend that loses every p2c comparison (and
+// so never runs Update) still decays back toward zero instead of keeping a
+// stale high reading forever.
+func (e *ewmaStat) cost() float64 {
+	inflight := atomic.LoadInt32(&e.inflight)
+	e.mu.Lock()
+	value := e.value
+	last := e.lastSample
+	e.mu.Unlock()
+	if last != 0 {
+		elapsed := time.Duration(time.Now().UnixNano() - last)
+		decay := 1 - math.Exp(-float64(elapsed)/float64(ewmaDecayWindow))
+		value -= value * decay
+	}
+	return value * float64(inflight+1)
+}
@@ -0,0 +1,38 @@
+package upstream
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEwmaStatUpdateAndCost(t *testing.T) {
+	e := &ewmaStat{}
+	e.Update(100 * time.Millisecond)
+	if e.cost() <= 0 {
+		t.Fatalf("expect a positive cost after a sample, got %v", e.cost())
+	}
+
+	e.Inc()
+	withInflight := e.cost()
+	e.Dec()
+	withoutInflight := e.cost()
+	if withInflight <= withoutInflight {
+		t.Fatalf("expect cost to increase with in-flight requests: with=%v without=%v", withInflight, withoutInflight)
+	}
+}
+
+func TestEwmaStatDecaysWhenIdle(t *testing.T) {
+	e := &ewmaStat{}
+	e.Update(time.Second)
+	fresh := e.cost()
+
+	// simulate the sample having happened long ago without ever calling
+	// Update again (i.e. this backend keeps losing p2c comparisons)
+	atomic.StoreInt64(&e.lastSample, time.Now().Add(-time.Hour).UnixNano())
+	decayed := e.cost()
+
+	if decayed >= fresh {
+		t.Fatalf("expect an idle backend's cost to decay toward zero: fresh=%v decayed=%v", fresh, decayed)
+	}
+}
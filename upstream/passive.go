@@ -0,0 +1,155 @@
+package upstream
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vicanso/pike/log"
+)
+
+const (
+	defaultPassiveMaxFails      = 3
+	defaultPassiveFailTimeout   = 10 * time.Second
+	defaultPassiveRecoverChecks = 2
+	passiveRecheckInterval      = time.Second
+)
+
+// passiveState tracks the consecutive failure/recovery counts the proxy
+// handler has observed for a single backend, independent of the active
+// Ping loop
+type passiveState struct {
+	mu         sync.Mutex
+	fails      int
+	lastFailAt time.Time
+	recovers   int
+	removed    bool
+}
+
+// recordPassiveFailure increments the failure count (resetting it first if
+// the previous failure fell outside the configured window) and, once it
+// crosses maxFails, removes the backend from rotation and starts an
+// accelerated recheck loop
+func (us *Upstream) recordPassiveFailure(addr string, retryAfter time.Duration) {
+	cfg := us.passiveCfg
+	st := us.passiveStateFor(addr)
+	now := time.Now()
+	st.mu.Lock()
+	if !st.lastFailAt.IsZero() && now.Sub(st.lastFailAt) > cfg.failTimeout() {
+		st.fails = 0
+	}
+	st.fails++
+	st.lastFailAt = now
+	st.recovers = 0
+	trip := !st.removed && st.fails >= cfg.maxFails()
+	if trip {
+		st.removed = true
+	}
+	st.mu.Unlock()
+	if !trip {
+		return
+	}
+	server := &us.server
+	server.Remove(addr)
+	log.Default().Warn("passive health check removed backend " + addr)
+	go us.recheck(addr, retryAfter)
+}
+
+// recordPassiveSuccess resets the failure count observed for addr
+func (us *Upstream) recordPassiveSuccess(addr string) {
+	st := us.passiveStateFor(addr)
+	st.mu.Lock()
+	st.fails = 0
+	st.mu.Unlock()
+}
+
+// recheck accelerated-probes addr (waiting out any Retry-After delay
+// first) until recoverChecks consecutive probes pass, then adds it back
+func (us *Upstream) recheck(addr string, delay time.Duration) {
+	cfg := us.passiveCfg
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	server := &us.server
+	for {
+		time.Sleep(passiveRecheckInterval)
+		healthy := probe(addr + server.Ping)
+		st := us.passiveStateFor(addr)
+		st.mu.Lock()
+		if healthy {
+			st.recovers++
+		} else {
+			st.recovers = 0
+		}
+		done := st.recovers >= cfg.recoverChecks()
+		if done {
+			st.removed = false
+			st.fails = 0
+			st.recovers = 0
+		}
+		st.mu.Unlock()
+		if done {
+			server.Add(addr)
+			log.Default().Info("passive health check restored backend " + addr)
+			return
+		}
+	}
+}
+
+// probe performs a single http check against url
+func probe(url string) bool {
+	client := http.Client{
+		Timeout: 3 * time.Second,
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusBadRequest
+}
+
+// passiveStateFor returns (creating if needed) the passiveState for addr
+func (us *Upstream) passiveStateFor(addr string) *passiveState {
+	if v, ok := us.passiveStates.Load(addr); ok {
+		return v.(*passiveState)
+	}
+	st := &passiveState{}
+	actual, _ := us.passiveStates.LoadOrStore(addr, st)
+	return actual.(*passiveState)
+}
+
+func (cfg *PassiveConfig) maxFails() int {
+	if cfg == nil || cfg.MaxFails <= 0 {
+		return defaultPassiveMaxFails
+	}
+	return cfg.MaxFails
+}
+
+func (cfg *PassiveConfig) recoverChecks() int {
+	if cfg == nil || cfg.RecoverChecks <= 0 {
+		return defaultPassiveRecoverChecks
+	}
+	return cfg.RecoverChecks
+}
+
+func (cfg *PassiveConfig) failTimeout() time.Duration {
+	if cfg == nil || cfg.FailTimeout <= 0 {
+		return defaultPassiveFailTimeout
+	}
+	return time.Duration(cfg.FailTimeout) * time.Second
+}
+
+// parseRetryAfter parses a Retry-After header's seconds form into a
+// duration, returning 0 if absent or unparsable
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,54 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPassiveConfigDefaults(t *testing.T) {
+	var cfg *PassiveConfig
+	if cfg.maxFails() != defaultPassiveMaxFails {
+		t.Fatalf("expect default maxFails %d, got %d", defaultPassiveMaxFails, cfg.maxFails())
+	}
+	if cfg.recoverChecks() != defaultPassiveRecoverChecks {
+		t.Fatalf("expect default recoverChecks %d, got %d", defaultPassiveRecoverChecks, cfg.recoverChecks())
+	}
+	if cfg.failTimeout() != defaultPassiveFailTimeout {
+		t.Fatalf("expect default failTimeout %v, got %v", defaultPassiveFailTimeout, cfg.failTimeout())
+	}
+}
+
+func TestPassiveConfigOverrides(t *testing.T) {
+	cfg := &PassiveConfig{
+		MaxFails:      5,
+		FailTimeout:   30,
+		RecoverChecks: 4,
+	}
+	if cfg.maxFails() != 5 {
+		t.Fatalf("expect maxFails 5, got %d", cfg.maxFails())
+	}
+	if cfg.recoverChecks() != 4 {
+		t.Fatalf("expect recoverChecks 4, got %d", cfg.recoverChecks())
+	}
+	if cfg.failTimeout() != 30*time.Second {
+		t.Fatalf("expect failTimeout 30s, got %v", cfg.failTimeout())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	cases := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{"not-a-number", 0},
+	}
+	for _, c := range cases {
+		got := parseRetryAfter(c.value)
+		if got != c.want {
+			t.Fatalf("parseRetryAfter(%q) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
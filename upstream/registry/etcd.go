@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vicanso/pike/log"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// EtcdRegistry etcd-backed Registry. Each backend registers itself under
+// prefix+addr as a JSON {addr,backup,weight} value with a TTL lease;
+// lease expiry surfaces as a normal etcd delete event, so it is treated
+// the same as an explicit backend removal.
+type EtcdRegistry struct {
+	client *clientv3.Client
+
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+}
+
+// NewEtcdRegistry creates an EtcdRegistry connected to endpoints
+func NewEtcdRegistry(endpoints []string) (*EtcdRegistry, error) {
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdRegistry{
+		client: c,
+	}, nil
+}
+
+// Watch implements Registry
+func (r *EtcdRegistry) Watch(name, prefix string, fn UpdateFunc) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.cancels = append(r.cancels, cancel)
+	r.mu.Unlock()
+
+	backends, err := r.load(ctx, prefix)
+	if err != nil {
+		cancel()
+		return err
+	}
+	fn(name, backends)
+
+	go r.watch(ctx, name, prefix, backends, fn)
+	return nil
+}
+
+// load fetches the current full snapshot under prefix
+func (r *EtcdRegistry) load(ctx context.Context, prefix string) ([]Backend, error) {
+	resp, err := r.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	backends := make(map[string]Backend, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		b := Backend{}
+		if err := json.Unmarshal(kv.Value, &b); err != nil {
+			continue
+		}
+		backends[string(kv.Key)] = b
+	}
+	return toSnapshot(backends), nil
+}
+
+// watch keeps the snapshot up to date with incremental events, rebuilding
+// the watch (and reloading the full list) whenever the channel is closed
+// by a reconnect so no events are silently missed
+func (r *EtcdRegistry) watch(ctx context.Context, name, prefix string, initial []Backend, fn UpdateFunc) {
+	current := make(map[string]Backend, len(initial))
+	for _, b := range initial {
+		current[prefix+b.Addr] = b
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		wc := r.client.Watch(ctx, prefix, clientv3.WithPrefix())
+		for resp := range wc {
+			if resp.Canceled {
+				break
+			}
+			for _, ev := range resp.Events {
+				key := string(ev.Kv.Key)
+				switch ev.Type {
+				case clientv3.EventTypeDelete:
+					delete(current, key)
+				default:
+					b := Backend{}
+					if err := json.Unmarshal(ev.Kv.Value, &b); err != nil {
+						continue
+					}
+					current[key] = b
+				}
+			}
+			fn(name, toSnapshot(current))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Default().Warn("etcd watch channel closed, reconnecting")
+		time.Sleep(time.Second)
+		backends, err := r.load(ctx, prefix)
+		if err != nil {
+			continue
+		}
+		current = make(map[string]Backend, len(backends))
+		for _, b := range backends {
+			current[prefix+b.Addr] = b
+		}
+		fn(name, toSnapshot(current))
+	}
+}
+
+// toSnapshot returns a deterministically ordered slice of the backend set
+func toSnapshot(backends map[string]Backend) []Backend {
+	snapshot := make([]Backend, 0, len(backends))
+	for _, b := range backends {
+		snapshot = append(snapshot, b)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Addr < snapshot[j].Addr
+	})
+	return snapshot
+}
+
+// Close implements Registry
+func (r *EtcdRegistry) Close() error {
+	r.mu.Lock()
+	for _, cancel := range r.cancels {
+		cancel()
+	}
+	r.cancels = nil
+	r.mu.Unlock()
+	return r.client.Close()
+}
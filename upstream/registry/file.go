@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// FileRegistry a file-based Registry used as a fallback when no etcd
+// cluster is configured (and in tests): it reads a JSON array of Backend
+// from Path and delivers it as a single initial-load snapshot. It does
+// not watch for later changes.
+type FileRegistry struct {
+	Path string
+}
+
+// NewFileRegistry creates a FileRegistry reading backends from path
+func NewFileRegistry(path string) *FileRegistry {
+	return &FileRegistry{
+		Path: path,
+	}
+}
+
+// Watch implements Registry
+func (r *FileRegistry) Watch(name, prefix string, fn UpdateFunc) error {
+	buf, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return err
+	}
+	backends := make([]Backend, 0)
+	if err := json.Unmarshal(buf, &backends); err != nil {
+		return err
+	}
+	fn(name, backends)
+	return nil
+}
+
+// Close implements Registry
+func (r *FileRegistry) Close() error {
+	return nil
+}
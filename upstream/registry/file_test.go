@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileRegistryWatch(t *testing.T) {
+	f, err := ioutil.TempFile("", "pike-registry-*.json")
+	if err != nil {
+		t.Fatalf("create temp file fail, %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`[{"addr":"http://127.0.0.1:5000","weight":1},{"addr":"http://127.0.0.1:5001","backup":true}]`)
+	if err != nil {
+		t.Fatalf("write temp file fail, %v", err)
+	}
+	f.Close()
+
+	r := NewFileRegistry(f.Name())
+	var got []Backend
+	err = r.Watch("test", "", func(name string, backends []Backend) {
+		got = backends
+	})
+	if err != nil {
+		t.Fatalf("watch fail, %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expect 2 backends, got %d", len(got))
+	}
+	if got[0].Addr != "http://127.0.0.1:5000" || got[0].Backup {
+		t.Fatalf("unexpected first backend, %+v", got[0])
+	}
+	if got[1].Addr != "http://127.0.0.1:5001" || !got[1].Backup {
+		t.Fatalf("unexpected second backend, %+v", got[1])
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("close fail, %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+// Package registry defines a pluggable backend-discovery abstraction so an
+// Upstream's backend list can be kept in sync with an external source
+// (etcd, Consul, a static file, ...) instead of only the static list
+// configured in backends.yml.
+package registry
+
+type (
+	// Backend a backend instance discovered from a registry
+	Backend struct {
+		Addr   string `json:"addr"`
+		Backup bool   `json:"backup"`
+		Weight int    `json:"weight"`
+	}
+	// UpdateFunc called with the full current backend snapshot for name,
+	// on the initial load and again for every subsequent change (watch
+	// event or lease expiry)
+	UpdateFunc func(name string, backends []Backend)
+	// Registry backend discovery source
+	Registry interface {
+		// Watch starts watching prefix for name, invoking fn once with
+		// the initial full snapshot and again on every later change
+		Watch(name, prefix string, fn UpdateFunc) error
+		// Close stops all watches and releases underlying resources
+		Close() error
+	}
+)
@@ -3,14 +3,19 @@ package upstream
 import (
 	"hash/fnv"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/vicanso/cod"
 	"github.com/vicanso/hes"
+	"github.com/vicanso/pike/log"
+	"github.com/vicanso/pike/upstream/registry"
 	"github.com/vicanso/pike/util"
 	up "github.com/vicanso/upstream"
 
@@ -45,8 +50,17 @@ const (
 	policyRoundRobin = "roundRobin"
 	policyLeastconn  = "leastconn"
 	policyIPHash     = "ipHash"
+	policyP2CEWMA    = "p2cEWMA"
 	headerHashPrefix = "header:"
 	cookieHashPrefix = "cookie:"
+
+	// registryTypeEtcd discover backends from an etcd cluster
+	registryTypeEtcd = "etcd"
+	// registryTypeFile discover backends from a local json file (mainly for tests)
+	registryTypeFile = "file"
+
+	headerRetryAfter = "Retry-After"
+	headerXRequestID = "X-Request-Id"
 )
 
 type (
@@ -61,6 +75,42 @@ type (
 		Hosts         []string
 		Backends      []string
 		Rewrites      []string
+		Registry      *RegistryConfig
+		Breaker       *BreakerConfig
+		Passive       *PassiveConfig
+	}
+	// PassiveConfig configures passive health checking (backend
+	// availability inferred from real proxy traffic) for a Backend
+	PassiveConfig struct {
+		// MaxFails consecutive failures within FailTimeout before a backend is removed, defaults to 3
+		MaxFails int `yaml:"maxFails"`
+		// FailTimeout window (seconds) the consecutive failures must occur within, defaults to 10
+		FailTimeout int `yaml:"failTimeout"`
+		// RecoverChecks consecutive passing accelerated probes required before re-adding, defaults to 2
+		RecoverChecks int `yaml:"recoverChecks"`
+	}
+	// BreakerConfig configures the per-backend circuit breaker for a Backend
+	BreakerConfig struct {
+		// FailureRatio fraction of failed requests in the rolling window that trips the breaker, defaults to 0.5
+		FailureRatio float64 `yaml:"failureRatio"`
+		// MinRequestVolume minimum requests in the rolling window before the ratio is evaluated, defaults to 20
+		MinRequestVolume uint32 `yaml:"minRequestVolume"`
+		// Buckets number of 1s rolling-window buckets, defaults to 10
+		Buckets int
+		// CooldownSeconds how long the breaker stays open before allowing a probe request, defaults to 10
+		CooldownSeconds int `yaml:"cooldownSeconds"`
+	}
+	// RegistryConfig configures dynamic backend discovery for a Backend,
+	// layered on top of (or instead of) the static Backends list
+	RegistryConfig struct {
+		// Type registry type, etcd or file, defaults to etcd
+		Type string
+		// Endpoints etcd endpoints, only used when Type is etcd
+		Endpoints []string
+		// Prefix key prefix each backend registers itself under, e.g. /pike/backends/<name>/
+		Prefix string
+		// File path to a json backend list, only used when Type is file
+		File string
 	}
 	// Upstream Upstream
 	Upstream struct {
@@ -74,6 +124,18 @@ type (
 		Prefixs       []string
 		Rewrites      []string
 		Handler       cod.Handler
+		// registry current registry used for dynamic backend discovery, nil if none configured
+		registry registry.Registry
+		// breakerCfg circuit breaker config for this upstream's backends, nil disables breaking
+		breakerCfg *BreakerConfig
+		// breakers one circuit breaker per backend URL, created lazily
+		breakers sync.Map
+		// ewmas one ewmaStat per backend URL, used by policyP2CEWMA, created lazily
+		ewmas sync.Map
+		// passiveCfg passive health check config, nil disables it
+		passiveCfg *PassiveConfig
+		// passiveStates one passiveState per backend URL, created lazily
+		passiveStates sync.Map
 	}
 	// Upstreams upstream list
 	Upstreams []*Upstream
@@ -127,7 +189,10 @@ func Proxy(c *cod.Context) (err error) {
 	if found == nil {
 		return errNoMatchUpstream
 	}
-	return found.Handler(c)
+	start := time.Now()
+	err = found.Handler(c)
+	logAccess(c, found, start, err)
+	return err
 }
 
 // hash calculates a hash based on string s
@@ -168,6 +233,18 @@ func createProxyHandler(us *Upstream) cod.Handler {
 			c.Set(df.ProxyDoneCallback, result.Dec)
 		case policyIPHash:
 			result = server.GetAvailableUpstream(hash(c.RealIP()))
+		case policyP2CEWMA:
+			result = us.pickP2CEWMA(server.GetAvailableUpstream(rand.Uint32()), server.GetAvailableUpstream(rand.Uint32()))
+			if result != nil {
+				stat := us.ewmaFor(result.URL.String())
+				stat.Inc()
+				start := time.Now()
+				// 请求完成时更新ewma延迟并减少in-flight计数
+				c.Set(df.ProxyDoneCallback, func() {
+					stat.Dec()
+					stat.Update(time.Since(start))
+				})
+			}
 		default:
 			var index uint32
 			if isHeaderPolicy {
@@ -183,7 +260,15 @@ func createProxyHandler(us *Upstream) cod.Handler {
 		if result == nil {
 			return nil, errNoAvailableUpstream
 		}
-		return result.URL, nil
+		if us.breakerCfg != nil {
+			result = us.skipTrippedBreakers(server, result)
+			if result == nil {
+				return nil, errNoAvailableUpstream
+			}
+		}
+		target := result.URL
+		c.Set(backendAddrKey, target.String())
+		return target, nil
 	}
 
 	cfg := proxy.Config{
@@ -192,7 +277,122 @@ func createProxyHandler(us *Upstream) cod.Handler {
 	if len(us.Rewrites) != 0 {
 		cfg.Rewrites = us.Rewrites
 	}
-	return proxy.New(cfg)
+	handler := proxy.New(cfg)
+	if us.breakerCfg == nil && us.passiveCfg == nil {
+		return handler
+	}
+	return func(c *cod.Context) error {
+		err := handler(c)
+		us.recordBreakerOutcome(c, err)
+		us.recordPassiveOutcome(c, err)
+		return err
+	}
+}
+
+// backendAddrKey context key the chosen backend URL is stashed under so the
+// outcome can be recorded against the right breaker/passive state once the
+// proxied request completes
+const backendAddrKey = "pikeBackendAddr"
+
+// pickP2CEWMA picks whichever of a, b has the lower ewma_latency*(inflight+1)
+// cost, implementing power-of-two-choices load balancing
+func (us *Upstream) pickP2CEWMA(a, b *up.HTTPUpstream) *up.HTTPUpstream {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if us.ewmaFor(a.URL.String()).cost() <= us.ewmaFor(b.URL.String()).cost() {
+		return a
+	}
+	return b
+}
+
+// ewmaFor returns (creating if needed) the ewmaStat for backend
+func (us *Upstream) ewmaFor(backend string) *ewmaStat {
+	if v, ok := us.ewmas.Load(backend); ok {
+		return v.(*ewmaStat)
+	}
+	stat := &ewmaStat{}
+	actual, _ := us.ewmas.LoadOrStore(backend, stat)
+	return actual.(*ewmaStat)
+}
+
+// maxBreakerReselects bounds how many times skipTrippedBreakers retries a
+// fresh random pick against the available pool before giving up
+const maxBreakerReselects = 3
+
+// skipTrippedBreakers returns first if its breaker allows traffic;
+// otherwise it retries against fresh random picks from the available pool
+// so a single tripped backend doesn't fail requests other backends could
+// still serve. Returns nil if no allowed backend is found within the
+// retry budget.
+func (us *Upstream) skipTrippedBreakers(server *up.HTTP, first *up.HTTPUpstream) *up.HTTPUpstream {
+	candidate := first
+	for attempt := 0; attempt <= maxBreakerReselects; attempt++ {
+		if candidate == nil {
+			return nil
+		}
+		if us.breakerFor(candidate.URL.String()).Allow() {
+			return candidate
+		}
+		candidate = server.GetAvailableUpstream(rand.Uint32())
+	}
+	return nil
+}
+
+// breakerFor returns (creating if needed) the circuit breaker for backend
+func (us *Upstream) breakerFor(backend string) *breaker {
+	if v, ok := us.breakers.Load(backend); ok {
+		return v.(*breaker)
+	}
+	b := newBreaker(backend, *us.breakerCfg)
+	actual, _ := us.breakers.LoadOrStore(backend, b)
+	return actual.(*breaker)
+}
+
+// recordBreakerOutcome feeds the result of a proxied request back into the
+// circuit breaker for the backend that served it
+func (us *Upstream) recordBreakerOutcome(c *cod.Context, err error) {
+	if us.breakerCfg == nil {
+		return
+	}
+	backend := backendAddr(c)
+	if backend == "" {
+		return
+	}
+	b := us.breakerFor(backend)
+	if err != nil || c.StatusCode >= http.StatusInternalServerError {
+		b.RecordFailure()
+		return
+	}
+	b.RecordSuccess()
+}
+
+// recordPassiveOutcome feeds the result of a proxied request into the
+// passive health check state for the backend that served it
+func (us *Upstream) recordPassiveOutcome(c *cod.Context, err error) {
+	if us.passiveCfg == nil {
+		return
+	}
+	backend := backendAddr(c)
+	if backend == "" {
+		return
+	}
+	if err == nil && c.StatusCode < http.StatusInternalServerError {
+		us.recordPassiveSuccess(backend)
+		return
+	}
+	retryAfter := parseRetryAfter(c.GetHeader(headerRetryAfter))
+	us.recordPassiveFailure(backend, retryAfter)
+}
+
+// backendAddr reads the backend URL stashed by the target picker
+func backendAddr(c *cod.Context) string {
+	v, _ := c.Get(backendAddrKey)
+	addr, _ := v.(string)
+	return addr
 }
 
 func createUpstreamFromBackend(backend Backend) *Upstream {
@@ -222,13 +422,15 @@ func createUpstreamFromBackend(backend Backend) *Upstream {
 	}
 
 	us := Upstream{
-		Policy:   backend.Policy,
-		Name:     backend.Name,
-		server:   uh,
-		Prefixs:  backend.Prefixs,
-		Hosts:    backend.Hosts,
-		Rewrites: backend.Rewrites,
-		Priority: priority,
+		Policy:     backend.Policy,
+		Name:       backend.Name,
+		server:     uh,
+		Prefixs:    backend.Prefixs,
+		Hosts:      backend.Hosts,
+		Rewrites:   backend.Rewrites,
+		Priority:   priority,
+		breakerCfg: backend.Breaker,
+		passiveCfg: backend.Passive,
 	}
 	// 默认使用 round robin算法
 	if us.Policy == "" {
@@ -253,9 +455,68 @@ func NewUpstream(backend Backend) *Upstream {
 	us.Handler = createProxyHandler(us)
 	server.DoHealthCheck()
 	go server.StartHealthCheck()
+	if backend.Registry != nil {
+		err := us.watchRegistry(backend.Registry)
+		if err != nil {
+			log.Default().Error(err.Error())
+		}
+	}
 	return us
 }
 
+// createRegistry creates the registry described by cfg
+func createRegistry(cfg *RegistryConfig) (registry.Registry, error) {
+	switch cfg.Type {
+	case registryTypeFile:
+		return registry.NewFileRegistry(cfg.File), nil
+	default:
+		return registry.NewEtcdRegistry(cfg.Endpoints)
+	}
+}
+
+// watchRegistry subscribes us to cfg's registry so that us.server's
+// backend list stays in sync with the external source without a restart
+func (us *Upstream) watchRegistry(cfg *RegistryConfig) error {
+	reg, err := createRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	us.registry = reg
+	server := &us.server
+	applied := make(map[string]registry.Backend)
+	return reg.Watch(us.Name, cfg.Prefix, func(name string, backends []registry.Backend) {
+		// us.server (up.HTTP) is the lock-free live view the picker reads;
+		// it is safe for concurrent Add/Remove and lookups, so there is no
+		// separate snapshot to maintain here beyond the diff below
+		next := make(map[string]registry.Backend, len(backends))
+		for _, b := range backends {
+			next[b.Addr] = b
+		}
+		for addr, b := range next {
+			if _, ok := applied[addr]; ok {
+				continue
+			}
+			if b.Backup {
+				server.AddBackup(addr)
+			} else {
+				server.Add(addr)
+			}
+		}
+		for addr, b := range applied {
+			if _, ok := next[addr]; ok {
+				continue
+			}
+			if b.Backup {
+				server.RemoveBackup(addr)
+			} else {
+				server.Remove(addr)
+			}
+		}
+		applied = next
+		log.Default().Info("upstream " + name + " backends updated from registry")
+	})
+}
+
 // Match match
 func (us *Upstream) Match(c *cod.Context) bool {
 	hosts := us.Hosts